@@ -0,0 +1,164 @@
+package deepcopy
+
+import (
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// DiscoverTypes finds the types of pkg that opt in to generation via a
+// "+<tag-prefix>-gen=true" doc comment marker (whether exported or not, and
+// without checking that they're structs), Kubernetes
+// gengo style. A package-level "+<tag-prefix>-gen=package" marker (as found
+// in a doc.go file's package comment) flips the default to "every exported
+// struct type" (non-struct exported types, e.g. enums or interfaces, are
+// never swept up by the package default), in which case a type can opt
+// back out with "+<tag-prefix>-gen=false". It's exported so a caller
+// iterating over several packages matched by a single recursive pattern
+// (and given no explicit -type list) can check ahead of Generate whether a
+// package has anything to do; see run in main.go.
+func (g Generator) DiscoverTypes(pkg *packages.Package) ([]string, error) {
+	packageDefault := g.packageMarker(pkg)
+
+	found := map[string]struct{}{}
+
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range genDecl.Specs {
+				typeSpec, ok := spec.(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+
+				doc := typeSpec.Doc
+				if doc == nil {
+					doc = genDecl.Doc
+				}
+
+				switch g.typeMarker(doc) {
+				case markerTrue:
+					found[typeSpec.Name.Name] = struct{}{}
+				case markerFalse:
+					// explicit opt-out, never included
+				default:
+					// The package-default only sweeps up struct types: an
+					// enum, interface, or alias has no fields to deep copy,
+					// and silently skipping it (rather than collecting it
+					// and having Generate's lookupStruct reject it) is what
+					// lets "+<tag-prefix>-gen=package" work on a package
+					// that exports more than just structs, same as
+					// TypesPresent does for the explicit -type path.
+					if packageDefault && typeSpec.Name.IsExported() {
+						if _, err := lookupStruct(pkg, typeSpec.Name.Name); err == nil {
+							found[typeSpec.Name.Name] = struct{}{}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+type typeMarkerValue int
+
+const (
+	markerUnset typeMarkerValue = iota
+	markerTrue
+	markerFalse
+)
+
+// typeMarker inspects a type's doc comment for "+<tag-prefix>-gen=true" or
+// "+<tag-prefix>-gen=false".
+func (g Generator) typeMarker(doc *ast.CommentGroup) typeMarkerValue {
+	prefix := "+" + g.tagPrefix + "-gen="
+
+	for _, line := range commentLines(doc) {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		switch strings.TrimSpace(strings.TrimPrefix(line, prefix)) {
+		case "true":
+			return markerTrue
+		case "false":
+			return markerFalse
+		}
+	}
+
+	return markerUnset
+}
+
+// packageMarker reports whether any file in pkg carries a package-level
+// "+<tag-prefix>-gen=package" doc comment, which is conventionally placed
+// in a doc.go file alongside the package clause.
+func (g Generator) packageMarker(pkg *packages.Package) bool {
+	marker := "+" + g.tagPrefix + "-gen=package"
+
+	for _, file := range pkg.Syntax {
+		for _, line := range commentLines(file.Doc) {
+			if line == marker {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// findTypeDoc returns the doc comment attached to the type named name,
+// preferring a comment directly above the type spec over one shared by the
+// whole `type ( ... )` block.
+func findTypeDoc(pkg *packages.Package, name string) *ast.CommentGroup {
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range genDecl.Specs {
+				typeSpec, ok := spec.(*ast.TypeSpec)
+				if !ok || typeSpec.Name.Name != name {
+					continue
+				}
+
+				if typeSpec.Doc != nil {
+					return typeSpec.Doc
+				}
+				return genDecl.Doc
+			}
+		}
+	}
+
+	return nil
+}
+
+func commentLines(doc *ast.CommentGroup) []string {
+	if doc == nil {
+		return nil
+	}
+
+	lines := make([]string, 0, len(doc.List))
+	for _, c := range doc.List {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+		lines = append(lines, text)
+	}
+
+	return lines
+}
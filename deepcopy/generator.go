@@ -0,0 +1,206 @@
+// Package deepcopy renders DeepCopy methods for Go struct types by walking
+// their fields with go/types. It backs the deep-copy command line tool but
+// is kept independent of flag parsing so it can be driven from other tools.
+package deepcopy
+
+import (
+	"fmt"
+	"go/types"
+	"io"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// defaultTagPrefix is the marker namespace used to discover types when no
+// -type flags are given, e.g. "+deepcopy-gen=true".
+const defaultTagPrefix = "deepcopy"
+
+// SkipLists holds, for each requested type in order, the set of field
+// selectors that should be shallow-copied instead of deep-copied.
+type SkipLists []map[string]struct{}
+
+func (s SkipLists) forIndex(i int) map[string]struct{} {
+	if i < 0 || i >= len(s) {
+		return nil
+	}
+	return s[i]
+}
+
+// Generator renders DeepCopy methods for the types it is asked to handle.
+// It is intentionally immutable: NewGenerator and the With* helpers return
+// a configured copy rather than mutating in place.
+type Generator struct {
+	pointerReceiver bool
+	method          string
+	skips           SkipLists
+	maxDepth        int
+	anotherStruct   bool
+
+	returnInterface        string
+	returnInterfaceDep     string
+	returnInterfaceDepPath string
+
+	buildTags    []string
+	tagPrefix    string
+	into         bool
+	boundingDirs []string
+	cycleSafe    bool
+}
+
+// NewGenerator builds a Generator from the CLI flags of the deep-copy
+// command (main.go).
+func NewGenerator(
+	pointerReceiver bool,
+	method string,
+	skips SkipLists,
+	maxDepth int,
+	anotherStruct bool,
+	returnInterface, returnInterfaceDep, returnInterfaceDepPath string,
+	buildTags []string,
+) Generator {
+	return Generator{
+		pointerReceiver:        pointerReceiver,
+		method:                 method,
+		skips:                  skips,
+		maxDepth:               maxDepth,
+		anotherStruct:          anotherStruct,
+		returnInterface:        returnInterface,
+		returnInterfaceDep:     returnInterfaceDep,
+		returnInterfaceDepPath: returnInterfaceDepPath,
+		buildTags:              buildTags,
+		tagPrefix:              defaultTagPrefix,
+	}
+}
+
+// WithTagPrefix overrides the marker namespace used when discovering types
+// from doc comments, e.g. "foo" looks for "+foo-gen=true" instead of
+// "+deepcopy-gen=true". An empty prefix leaves the default in place.
+func (g Generator) WithTagPrefix(prefix string) Generator {
+	if prefix != "" {
+		g.tagPrefix = prefix
+	}
+	return g
+}
+
+// GenerateInto switches the emission style to the Kubernetes split-method
+// pattern: a DeepCopyInto(out *T) method that writes into a caller-allocated
+// destination, plus a thin DeepCopy() *T wrapper that allocates and
+// delegates to it. DeepCopyInto always uses pointer receiver/parameter
+// semantics, regardless of -pointer-receiver.
+func (g Generator) GenerateInto(into bool) Generator {
+	g.into = into
+	return g
+}
+
+// WithBoundingDirs restricts type graph traversal to types defined in a
+// package whose import path has one of dirs as a prefix. A referenced type
+// outside every bounding dir is treated as a leaf: the generator calls its
+// existing copy method if it has one, and otherwise leaves it to the plain
+// struct assignment instead of recursing into a package it doesn't own. An
+// empty dirs leaves traversal unrestricted.
+func (g Generator) WithBoundingDirs(dirs []string) Generator {
+	g.boundingDirs = dirs
+	return g
+}
+
+// WithCycleSafe switches to the runtime cycle-detection mode: generated
+// methods become internal deepCopyWithCtx(ctx *copyCtx) variants that track
+// already-cloned pointers in ctx, plus public wrappers that allocate a
+// fresh context. This safely handles self-referential and shared pointer
+// graphs that a static -maxdepth cutoff cannot, at the cost of changing
+// aliasing semantics for anyone relying on the old unconditional clone, so
+// it is opt-in and off by default. It takes priority over -into: a type
+// generated with both set still only gets the cycle-safe methods.
+func (g Generator) WithCycleSafe(cycleSafe bool) Generator {
+	g.cycleSafe = cycleSafe
+	return g
+}
+
+// Generate renders DeepCopy methods for typeNames in pkg and writes the
+// resulting Go source to w. If typeNames is empty, Generate falls back to
+// discovering types via +<tag-prefix>-gen doc comment markers instead of
+// failing; see discover.go.
+func (g Generator) Generate(w io.Writer, typeNames []string, pkg *packages.Package) error {
+	typeNames = withoutEmpty(typeNames)
+
+	if len(typeNames) == 0 {
+		discovered, err := g.DiscoverTypes(pkg)
+		if err != nil {
+			return fmt.Errorf("discovering marked types: %v", err)
+		}
+		if len(discovered) == 0 {
+			return fmt.Errorf("no type given and no +%s-gen markers found in %s", g.tagPrefix, pkg.PkgPath)
+		}
+		typeNames = discovered
+	}
+
+	batch := make(map[string]struct{}, len(typeNames))
+	for _, name := range typeNames {
+		batch[name] = struct{}{}
+	}
+
+	rend := newRenderer(g, pkg, batch)
+
+	for i, name := range typeNames {
+		st, err := lookupStruct(pkg, name)
+		if err != nil {
+			return err
+		}
+
+		markers := g.typeInterfaceMarkers(pkg, name)
+
+		if err := rend.renderType(name, st, g.skips.forIndex(i), markers); err != nil {
+			return fmt.Errorf("generating %s: %v", name, err)
+		}
+	}
+
+	return rend.writeTo(w)
+}
+
+// TypesPresent filters typeNames down to the ones that exist as named
+// struct types in pkg, preserving order. It lets a caller iterating over
+// several packages matched by a single explicit -type list (e.g. a
+// "./..." pattern matching more than one package) skip a package that
+// doesn't define one of the requested types instead of failing the whole
+// run; see run in main.go.
+func (g Generator) TypesPresent(pkg *packages.Package, typeNames []string) []string {
+	typeNames = withoutEmpty(typeNames)
+
+	present := make([]string, 0, len(typeNames))
+	for _, name := range typeNames {
+		if _, err := lookupStruct(pkg, name); err == nil {
+			present = append(present, name)
+		}
+	}
+
+	return present
+}
+
+func lookupStruct(pkg *packages.Package, name string) (*types.Struct, error) {
+	obj := pkg.Types.Scope().Lookup(name)
+	if obj == nil {
+		return nil, fmt.Errorf("type %s not found in package %s", name, pkg.PkgPath)
+	}
+
+	named, ok := obj.Type().(*types.Named)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a named type", name)
+	}
+
+	st, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a struct type", name)
+	}
+
+	return st, nil
+}
+
+func withoutEmpty(in []string) []string {
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
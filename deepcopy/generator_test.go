@@ -0,0 +1,638 @@
+package deepcopy_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+
+	"github.com/lexx13/deep-copy/deepcopy"
+)
+
+// buildAndRun writes structSrc and driverSrc into a throwaway module,
+// generates DeepCopy methods for types in -cycle-safe mode, and runs the
+// result with `go run`. It fails the test if generation, compilation, or
+// the program itself (driverSrc is expected to panic on failure) does not
+// succeed, and returns the program's stdout.
+func buildAndRun(t *testing.T, types []string, structSrc, driverSrc string) string {
+	t.Helper()
+	gen := deepcopy.NewGenerator(true, "DeepCopy", nil, 0, false, "", "", "", nil).WithCycleSafe(true)
+	return buildAndRunWithGen(t, gen, types, structSrc, driverSrc)
+}
+
+// buildAndRunWithGen is buildAndRun generalized over the Generator to use,
+// so modes other than -cycle-safe can be exercised the same way.
+func buildAndRunWithGen(t *testing.T, gen deepcopy.Generator, types []string, structSrc, driverSrc string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "go.mod"), "module fixture\n\ngo 1.21\n")
+	writeFile(t, filepath.Join(dir, "types.go"), structSrc)
+	writeFile(t, filepath.Join(dir, "main.go"), driverSrc)
+
+	pkgs, err := packages.Load(&packages.Config{
+		Dir: dir,
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedTypes | packages.NeedTypesInfo |
+			packages.NeedDeps | packages.NeedImports | packages.NeedSyntax,
+	}, ".")
+	if err != nil {
+		t.Fatalf("loading fixture package: %v", err)
+	}
+	if len(pkgs) != 1 {
+		t.Fatalf("expected one package, got %d", len(pkgs))
+	}
+
+	out, err := os.Create(filepath.Join(dir, "zz_generated.go"))
+	if err != nil {
+		t.Fatalf("creating generated file: %v", err)
+	}
+	if err := gen.Generate(out, types, pkgs[0]); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("closing generated file: %v", err)
+	}
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("go run failed: %v\nstderr:\n%s", err, stderr.String())
+	}
+
+	return stdout.String()
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("creating directory for %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+}
+
+// TestMarkerDiscovery_CompilesAndRuns generates plain (non -cycle-safe,
+// non -into) DeepCopy methods for types found via +deepcopy-gen=true doc
+// markers rather than an explicit -type list, for a struct with a pointer
+// field to another struct that itself has a slice field. That shape is the
+// one a naive pointer-field renderer fails to compile for.
+func TestMarkerDiscovery_CompilesAndRuns(t *testing.T) {
+	const structSrc = `package main
+
+// +deepcopy-gen=true
+type Box struct {
+	Items []*string
+}
+
+// +deepcopy-gen=true
+type Parent struct {
+	B *Box
+}
+`
+	const driverSrc = `package main
+
+import "fmt"
+
+func main() {
+	a, b := "a", "b"
+	p := &Parent{B: &Box{Items: []*string{&a, &b}}}
+	out := p.DeepCopy()
+
+	switch {
+	case out.B == p.B:
+		panic("pointer field was not cloned")
+	case &out.B.Items[0] == &p.B.Items[0]:
+		panic("slice inside the nested pointer field still aliases the original")
+	case *out.B.Items[0] != "a" || *out.B.Items[1] != "b":
+		panic("values were not copied")
+	}
+
+	fmt.Println("OK")
+}
+`
+
+	gen := deepcopy.NewGenerator(true, "DeepCopy", nil, 0, false, "", "", "", nil)
+
+	if out := buildAndRunWithGen(t, gen, nil, structSrc, driverSrc); out == "" {
+		t.Fatalf("expected program output, got none")
+	}
+}
+
+func TestCycleSafe_DoublyLinkedList(t *testing.T) {
+	const structSrc = `package main
+
+type Node struct {
+	Value int
+	Next  *Node
+	Prev  *Node
+}
+`
+	const driverSrc = `package main
+
+import "fmt"
+
+func main() {
+	a := &Node{Value: 1}
+	b := &Node{Value: 2}
+	c := &Node{Value: 3}
+	a.Next, b.Prev = b, a
+	b.Next, c.Prev = c, b
+
+	copyA := a.DeepCopy()
+
+	switch {
+	case copyA == a:
+		panic("head was not cloned")
+	case copyA.Next == b || copyA.Next.Next == c:
+		panic("Next pointers still alias the original list")
+	case copyA.Next.Prev != copyA:
+		panic("copied list lost its Prev back-link")
+	case copyA.Next.Next.Prev != copyA.Next:
+		panic("copied list lost its tail back-link")
+	case copyA.Value != 1 || copyA.Next.Value != 2 || copyA.Next.Next.Value != 3:
+		panic("values were not copied")
+	}
+
+	fmt.Println("OK")
+}
+`
+
+	if out := buildAndRun(t, []string{"Node"}, structSrc, driverSrc); out == "" {
+		t.Fatalf("expected program output, got none")
+	}
+}
+
+func TestCycleSafe_Diamond(t *testing.T) {
+	const structSrc = `package main
+
+type Leaf struct {
+	Value int
+}
+
+type Mid struct {
+	L *Leaf
+}
+
+type Root struct {
+	A *Mid
+	B *Mid
+}
+`
+	const driverSrc = `package main
+
+import "fmt"
+
+func main() {
+	shared := &Mid{L: &Leaf{Value: 42}}
+	root := &Root{A: shared, B: shared}
+
+	out := root.DeepCopy()
+
+	switch {
+	case out.A == shared || out.B == shared:
+		panic("diamond was not cloned")
+	case out.A != out.B:
+		panic("sharing between A and B was not preserved")
+	case out.A.L.Value != 42:
+		panic("leaf value was not copied")
+	}
+
+	fmt.Println("OK")
+}
+`
+
+	buildAndRun(t, []string{"Leaf", "Mid", "Root"}, structSrc, driverSrc)
+}
+
+func TestCycleSafe_SelfLoop(t *testing.T) {
+	const structSrc = `package main
+
+type Self struct {
+	Value int
+	Me    *Self
+}
+`
+	const driverSrc = `package main
+
+import "fmt"
+
+func main() {
+	s := &Self{Value: 7}
+	s.Me = s
+
+	out := s.DeepCopy()
+
+	switch {
+	case out == s:
+		panic("self was not cloned")
+	case out.Me != out:
+		panic("self-loop was not preserved on the copy")
+	case out.Value != 7:
+		panic("value was not copied")
+	}
+
+	fmt.Println("OK")
+}
+`
+
+	buildAndRun(t, []string{"Self"}, structSrc, driverSrc)
+}
+
+// TestCrossPackageImport generates copy methods for a type in package foo
+// that references another type in foo plus one in bar, then go builds the
+// result in a temp module. It guards against a naive qualifier printer that
+// emits "foo.Other" for a type living in the very package being generated
+// (which doesn't compile) or forgets to import "bar" for the type that
+// isn't (which also doesn't compile).
+func TestCrossPackageImport(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "go.mod"), "module fixture\n\ngo 1.21\n")
+	writeFile(t, filepath.Join(dir, "bar", "bar.go"), `package bar
+
+type Thing struct {
+	Name string
+}
+`)
+	writeFile(t, filepath.Join(dir, "foo", "types.go"), `package foo
+
+import "fixture/bar"
+
+type Other struct {
+	X int
+}
+
+type Main struct {
+	Locals  []*Other
+	Remotes []*bar.Thing
+}
+`)
+
+	pkgs, err := packages.Load(&packages.Config{
+		Dir: dir,
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedTypes | packages.NeedTypesInfo |
+			packages.NeedDeps | packages.NeedImports | packages.NeedSyntax,
+	}, "./foo")
+	if err != nil {
+		t.Fatalf("loading fixture package: %v", err)
+	}
+	if len(pkgs) != 1 {
+		t.Fatalf("expected one package, got %d", len(pkgs))
+	}
+
+	gen := deepcopy.NewGenerator(true, "DeepCopy", nil, 0, false, "", "", "", nil)
+
+	out, err := os.Create(filepath.Join(dir, "foo", "zz_generated.go"))
+	if err != nil {
+		t.Fatalf("creating generated file: %v", err)
+	}
+	if err := gen.Generate(out, []string{"Main"}, pkgs[0]); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("closing generated file: %v", err)
+	}
+
+	cmd := exec.Command("go", "build", "./...")
+	cmd.Dir = dir
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("go build failed: %v\nstderr:\n%s", err, stderr.String())
+	}
+}
+
+// TestInto_CompilesAndRuns generates the -into split-method style for a
+// type with a pointer field to a struct that has its own DeepCopyInto
+// method, plus a plain slice field, and exercises both DeepCopyInto and
+// the DeepCopy wrapper built on top of it.
+func TestInto_CompilesAndRuns(t *testing.T) {
+	const structSrc = `package main
+
+type Box struct {
+	Items []*string
+}
+
+type Parent struct {
+	B    *Box
+	Tags []*string
+}
+`
+	const driverSrc = `package main
+
+import "fmt"
+
+func main() {
+	a, b, x := "a", "b", "x"
+	p := &Parent{B: &Box{Items: []*string{&a, &b}}, Tags: []*string{&x}}
+
+	out := p.DeepCopy()
+	switch {
+	case out.B == p.B:
+		panic("DeepCopy: pointer field was not cloned")
+	case &out.B.Items[0] == &p.B.Items[0]:
+		panic("DeepCopy: nested slice still aliases the original")
+	case &out.Tags[0] == &p.Tags[0]:
+		panic("DeepCopy: slice field still aliases the original")
+	}
+
+	var dst Parent
+	p.DeepCopyInto(&dst)
+	switch {
+	case dst.B == p.B:
+		panic("DeepCopyInto: pointer field was not cloned")
+	case *dst.B.Items[0] != "a":
+		panic("DeepCopyInto: values were not copied")
+	}
+
+	fmt.Println("OK")
+}
+`
+
+	gen := deepcopy.NewGenerator(true, "DeepCopy", nil, 0, false, "", "", "", nil).GenerateInto(true)
+
+	if out := buildAndRunWithGen(t, gen, []string{"Box", "Parent"}, structSrc, driverSrc); out == "" {
+		t.Fatalf("expected program output, got none")
+	}
+}
+
+// TestInterfaceMarker generates a type in package model with a
+// "+deepcopy-gen:interfaces=fixture/iface.Cloner" marker referencing an
+// interface in a separate package iface, then go builds and runs the
+// result in a temp module, confirming the generated DeepCopyCloner method
+// both compiles against the foreign interface and returns a working clone.
+func TestInterfaceMarker(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "go.mod"), "module fixture\n\ngo 1.21\n")
+	writeFile(t, filepath.Join(dir, "iface", "iface.go"), `package iface
+
+type Cloner interface {
+	GetX() int
+}
+`)
+	writeFile(t, filepath.Join(dir, "model", "model.go"), `package model
+
+// +deepcopy-gen:interfaces=fixture/iface.Cloner
+type Main struct {
+	X int
+}
+
+func (m *Main) GetX() int { return m.X }
+`)
+	writeFile(t, filepath.Join(dir, "main.go"), `package main
+
+import (
+	"fmt"
+
+	"fixture/iface"
+	"fixture/model"
+)
+
+func main() {
+	m := &model.Main{X: 5}
+
+	var c iface.Cloner = m.DeepCopyCloner()
+	if c.GetX() != 5 {
+		panic("DeepCopyCloner did not return a working clone")
+	}
+
+	fmt.Println("OK")
+}
+`)
+
+	pkgs, err := packages.Load(&packages.Config{
+		Dir: filepath.Join(dir, "model"),
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedTypes | packages.NeedTypesInfo |
+			packages.NeedDeps | packages.NeedImports | packages.NeedSyntax,
+	}, ".")
+	if err != nil {
+		t.Fatalf("loading fixture package: %v", err)
+	}
+	if len(pkgs) != 1 {
+		t.Fatalf("expected one package, got %d", len(pkgs))
+	}
+
+	gen := deepcopy.NewGenerator(true, "DeepCopy", nil, 0, false, "", "", "", nil)
+
+	out, err := os.Create(filepath.Join(dir, "model", "zz_generated.go"))
+	if err != nil {
+		t.Fatalf("creating generated file: %v", err)
+	}
+	if err := gen.Generate(out, []string{"Main"}, pkgs[0]); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("closing generated file: %v", err)
+	}
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("go run failed: %v\nstderr:\n%s", err, stderr.String())
+	}
+	if stdout.String() == "" {
+		t.Fatalf("expected program output, got none")
+	}
+}
+
+// TestBoundingDirs generates copy methods scoped to package own via
+// -bounding-dirs, for a type with one pointer field into own itself and
+// one into an unrelated package vendor, then go builds and runs the
+// result. The in-bounds field should recurse normally; the out-of-bounds
+// one should stop at a shallow top-level clone instead of reaching into a
+// package the generator doesn't own.
+func TestBoundingDirs(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "go.mod"), "module fixture\n\ngo 1.21\n")
+	writeFile(t, filepath.Join(dir, "vendor", "vendor.go"), `package vendor
+
+type External struct {
+	Items []string
+}
+`)
+	writeFile(t, filepath.Join(dir, "own", "own.go"), `package own
+
+import "fixture/vendor"
+
+type Internal struct {
+	Items []string
+}
+
+type Main struct {
+	In  *Internal
+	Out *vendor.External
+}
+`)
+	writeFile(t, filepath.Join(dir, "main.go"), `package main
+
+import (
+	"fmt"
+
+	"fixture/own"
+)
+
+func main() {
+	m := &own.Main{
+		In:  &own.Internal{Items: []string{"a"}},
+		Out: nil,
+	}
+
+	out := m.DeepCopy()
+	if out.In == m.In {
+		panic("in-bounds pointer field was not cloned")
+	}
+
+	fmt.Println("OK")
+}
+`)
+
+	pkgs, err := packages.Load(&packages.Config{
+		Dir: filepath.Join(dir, "own"),
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedTypes | packages.NeedTypesInfo |
+			packages.NeedDeps | packages.NeedImports | packages.NeedSyntax,
+	}, ".")
+	if err != nil {
+		t.Fatalf("loading fixture package: %v", err)
+	}
+	if len(pkgs) != 1 {
+		t.Fatalf("expected one package, got %d", len(pkgs))
+	}
+
+	gen := deepcopy.NewGenerator(true, "DeepCopy", nil, 0, false, "", "", "", nil).
+		WithBoundingDirs([]string{"fixture/own"})
+
+	out, err := os.Create(filepath.Join(dir, "own", "zz_generated.go"))
+	if err != nil {
+		t.Fatalf("creating generated file: %v", err)
+	}
+	if err := gen.Generate(out, []string{"Internal", "Main"}, pkgs[0]); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("closing generated file: %v", err)
+	}
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("go run failed: %v\nstderr:\n%s", err, stderr.String())
+	}
+	if stdout.String() == "" {
+		t.Fatalf("expected program output, got none")
+	}
+}
+
+
+// TestTypesPresent covers the filtering run in main.go relies on to let a
+// single explicit -type list cover a "./..." pattern matching several
+// packages: a name present in the package is kept, one that isn't (or isn't
+// a struct) is dropped rather than reported as an error.
+func TestTypesPresent(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "go.mod"), "module fixture\n\ngo 1.21\n")
+	writeFile(t, filepath.Join(dir, "foo.go"), `package foo
+
+type Main struct {
+	X int
+}
+
+type Alias = int
+`)
+
+	pkgs, err := packages.Load(&packages.Config{
+		Dir: dir,
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedTypes | packages.NeedTypesInfo |
+			packages.NeedDeps | packages.NeedImports | packages.NeedSyntax,
+	}, ".")
+	if err != nil {
+		t.Fatalf("loading fixture package: %v", err)
+	}
+	if len(pkgs) != 1 {
+		t.Fatalf("expected one package, got %d", len(pkgs))
+	}
+
+	gen := deepcopy.NewGenerator(true, "DeepCopy", nil, 0, false, "", "", "", nil)
+
+	got := gen.TypesPresent(pkgs[0], []string{"Main", "Missing", "Alias", ""})
+	want := []string{"Main"}
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Fatalf("TypesPresent: got %v, want %v", got, want)
+	}
+}
+
+// TestPackageDefaultMarker_SkipsNonStructs covers +deepcopy-gen=package
+// discovery on a package that also exports non-struct types (an enum and an
+// interface): those aren't struct types lookupStruct can handle, so the
+// package default must skip them rather than collecting them and having
+// Generate fail on the first one it can't render.
+func TestPackageDefaultMarker_SkipsNonStructs(t *testing.T) {
+	const structSrc = `// +deepcopy-gen=package
+package main
+
+type Status int
+
+const (
+	StatusActive Status = iota
+	StatusInactive
+)
+
+type Cloner interface {
+	Clone() Cloner
+}
+
+type Box struct {
+	Items []*string
+}
+
+// +deepcopy-gen=false
+type Skipped struct {
+	X int
+}
+`
+	const driverSrc = `package main
+
+import "fmt"
+
+func main() {
+	a := "a"
+	b := &Box{Items: []*string{&a}}
+	out := b.DeepCopy()
+	if &out.Items[0] == &b.Items[0] {
+		panic("slice was not cloned")
+	}
+
+	fmt.Println("OK")
+}
+`
+
+	gen := deepcopy.NewGenerator(true, "DeepCopy", nil, 0, false, "", "", "", nil)
+
+	if out := buildAndRunWithGen(t, gen, nil, structSrc, driverSrc); out == "" {
+		t.Fatalf("expected program output, got none")
+	}
+}
@@ -0,0 +1,72 @@
+package deepcopy
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// interfaceMarkers describes the +<tag-prefix>-gen:interfaces markers
+// attached to a single type's doc comment.
+type interfaceMarkers struct {
+	// refs holds one "import/path.Identifier" entry per
+	// "+<tag-prefix>-gen:interfaces=..." marker, in source order.
+	refs []string
+	// nonPointerReceiver corresponds to a
+	// "+<tag-prefix>-gen:nonpointer-interfaces=true" marker: the generated
+	// interface methods get a value receiver even when -pointer-receiver
+	// is set for the main copy method.
+	nonPointerReceiver bool
+}
+
+// typeInterfaceMarkers scans name's doc comment in pkg for
+// "+<tag-prefix>-gen:interfaces=..." and
+// "+<tag-prefix>-gen:nonpointer-interfaces=true" markers, Kubernetes
+// k8s:deepcopy-gen:interfaces style.
+func (g Generator) typeInterfaceMarkers(pkg *packages.Package, name string) interfaceMarkers {
+	ifacePrefix := "+" + g.tagPrefix + "-gen:interfaces="
+	nonPtrMarker := "+" + g.tagPrefix + "-gen:nonpointer-interfaces=true"
+
+	var m interfaceMarkers
+
+	for _, line := range commentLines(findTypeDoc(pkg, name)) {
+		switch {
+		case strings.HasPrefix(line, ifacePrefix):
+			m.refs = append(m.refs, strings.TrimPrefix(line, ifacePrefix))
+		case line == nonPtrMarker:
+			m.nonPointerReceiver = true
+		}
+	}
+
+	return m
+}
+
+// interfaceRef is a parsed "import/path.Identifier" marker value.
+type interfaceRef struct {
+	importPath string
+	pkgName    string
+	ident      string
+}
+
+// parseInterfaceRef splits a "+<tag-prefix>-gen:interfaces=..." value into
+// its import path, inferred package name (the import path's last segment)
+// and interface identifier.
+func parseInterfaceRef(ref string) (interfaceRef, error) {
+	dot := strings.LastIndex(ref, ".")
+	if dot < 0 {
+		return interfaceRef{}, fmt.Errorf("interface marker %q: expected <import/path>.<Identifier>", ref)
+	}
+
+	importPath, ident := ref[:dot], ref[dot+1:]
+	if importPath == "" || ident == "" {
+		return interfaceRef{}, fmt.Errorf("interface marker %q: expected <import/path>.<Identifier>", ref)
+	}
+
+	pkgName := importPath
+	if slash := strings.LastIndex(importPath, "/"); slash >= 0 {
+		pkgName = importPath[slash+1:]
+	}
+
+	return interfaceRef{importPath: importPath, pkgName: pkgName, ident: ident}, nil
+}
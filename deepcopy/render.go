@@ -0,0 +1,465 @@
+package deepcopy
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// renderer accumulates the generated method bodies for one invocation of
+// Generate and knows how to print a single Go source file from them.
+type renderer struct {
+	gen Generator
+	pkg *packages.Package
+	// batch holds the names of the types requested in the current
+	// Generate call, so fields referencing another type in the same run
+	// can call its generated method even though it doesn't exist yet.
+	batch map[string]struct{}
+
+	body    bytes.Buffer
+	helpers bytes.Buffer
+	imports map[string]string // import path -> local name
+
+	// wroteCopyCtx guards against emitting the copyCtx helper type more
+	// than once when several -cycle-safe types are generated in one call.
+	wroteCopyCtx bool
+}
+
+func newRenderer(g Generator, pkg *packages.Package, batch map[string]struct{}) *renderer {
+	return &renderer{gen: g, pkg: pkg, batch: batch, imports: map[string]string{}}
+}
+
+// renderType writes the copy method(s) for the struct named name into r's
+// body buffer, shallow-copying any field selector present in skip, and
+// attaches any interface-returning methods requested via markers.
+func (r *renderer) renderType(name string, st *types.Struct, skip map[string]struct{}, markers interfaceMarkers) error {
+	var err error
+	switch {
+	case r.gen.cycleSafe:
+		err = r.renderTypeCycleSafe(name, st, skip)
+	case r.gen.into:
+		err = r.renderTypeInto(name, st, skip)
+	default:
+		err = r.renderTypeFlat(name, st, skip)
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.renderInterfaceMethods(name, markers)
+}
+
+// renderInterfaceMethods emits a DeepCopy<Interface>() pkg.Interface method
+// per "+<tag-prefix>-gen:interfaces=..." marker on name, each one calling
+// through to the main copy method and relying on Go to satisfy the
+// interface from its concrete result.
+func (r *renderer) renderInterfaceMethods(name string, markers interfaceMarkers) error {
+	for _, raw := range markers.refs {
+		ref, err := parseInterfaceRef(raw)
+		if err != nil {
+			return fmt.Errorf("type %s: %v", name, err)
+		}
+
+		receiver := "in " + name
+		if r.gen.pointerReceiver && !markers.nonPointerReceiver {
+			receiver = "in *" + name
+		}
+
+		qualified := ref.pkgName + "." + ref.ident
+		if ref.importPath != r.pkg.PkgPath {
+			r.imports[ref.importPath] = ref.pkgName
+		} else {
+			// The marker names this same package by its import path
+			// (copy-pasted from another type's marker, say): the
+			// interface lives right here, so no import or qualifier
+			// is needed, and one would be uncompilable besides.
+			qualified = ref.ident
+		}
+
+		fmt.Fprintf(&r.body, "\n// DeepCopy%s returns a deep copy of in as a %s.\nfunc (%s) DeepCopy%s() %s {\n\treturn in.%s()\n}\n",
+			ref.ident, qualified, receiver, ref.ident, qualified, r.gen.method)
+	}
+
+	return nil
+}
+
+// primaryReturnType applies the global -return-interface/-return-interface-dep
+// /-return-interface-dep-path flags to the main copy method's return type,
+// or leaves concrete unchanged when they're unset.
+func (r *renderer) primaryReturnType(concrete string) string {
+	if r.gen.returnInterface == "" {
+		return concrete
+	}
+
+	if r.gen.returnInterfaceDepPath != "" && r.gen.returnInterfaceDepPath != r.pkg.PkgPath {
+		r.imports[r.gen.returnInterfaceDepPath] = r.gen.returnInterfaceDep
+		return r.gen.returnInterfaceDep + "." + r.gen.returnInterface
+	}
+
+	return r.gen.returnInterface
+}
+
+// renderTypeFlat renders the original single-method style: DeepCopy (or
+// whatever -method is named) both allocates and fills the result.
+func (r *renderer) renderTypeFlat(name string, st *types.Struct, skip map[string]struct{}) error {
+	receiver := "in " + name
+	returnType := name
+	params := ""
+
+	var body bytes.Buffer
+
+	switch {
+	case r.gen.pointerReceiver && r.gen.anotherStruct:
+		receiver = "in *" + name
+		returnType = "*" + name
+		params = "another *" + name
+		fmt.Fprintf(&body, "\tif in == nil {\n\t\treturn nil\n\t}\n\n")
+		fmt.Fprintf(&body, "\tout := another\n\tif out == nil {\n\t\tout = new(%s)\n\t}\n\t*out = *in\n\n", name)
+
+	case r.gen.pointerReceiver:
+		receiver = "in *" + name
+		returnType = "*" + name
+		fmt.Fprintf(&body, "\tif in == nil {\n\t\treturn nil\n\t}\n\n")
+		fmt.Fprintf(&body, "\tout := new(%s)\n\t*out = *in\n\n", name)
+
+	default:
+		fmt.Fprintf(&body, "\tout := in\n\n")
+	}
+
+	if err := r.renderFields(&body, st, skip, 1, ""); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(&body, "\treturn out\n")
+
+	fmt.Fprintf(&r.body, "\n// %s returns a deep copy of in.\nfunc (%s) %s(%s) %s {\n%s}\n",
+		r.gen.method, receiver, r.gen.method, params, r.primaryReturnType(returnType), body.String())
+
+	return nil
+}
+
+// renderTypeInto renders the split-method style: DeepCopyInto fills a
+// caller-allocated destination, and the -method wrapper allocates one and
+// delegates to it. Both always use pointer semantics.
+func (r *renderer) renderTypeInto(name string, st *types.Struct, skip map[string]struct{}) error {
+	var into bytes.Buffer
+	fmt.Fprintf(&into, "\tif in == nil {\n\t\treturn\n\t}\n\n")
+	fmt.Fprintf(&into, "\t*out = *in\n\n")
+
+	if err := r.renderFields(&into, st, skip, 1, ""); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(&r.body, "\n// DeepCopyInto copies all fields of in into out, allocating new storage\n// for any field that aliases mutable state.\nfunc (in *%s) DeepCopyInto(out *%s) {\n%s}\n",
+		name, name, into.String())
+
+	params := ""
+	allocate := fmt.Sprintf("\tout := new(%s)\n", name)
+	if r.gen.anotherStruct {
+		params = "another *" + name
+		allocate = fmt.Sprintf("\tout := another\n\tif out == nil {\n\t\tout = new(%s)\n\t}\n", name)
+	}
+
+	fmt.Fprintf(&r.body, "\n// %s returns a deep copy of in.\nfunc (in *%s) %s(%s) %s {\n\tif in == nil {\n\t\treturn nil\n\t}\n\n%s\tin.DeepCopyInto(out)\n\n\treturn out\n}\n",
+		r.gen.method, name, r.gen.method, params, r.primaryReturnType("*"+name), allocate)
+
+	return nil
+}
+
+// renderTypeCycleSafe renders the -cycle-safe style: a deepCopyWithCtx(ctx
+// *copyCtx) method that consults ctx before cloning a pointer, so that a
+// cycle or a pointer shared by several parents is cloned exactly once, plus
+// a public wrapper that allocates a fresh context.
+func (r *renderer) renderTypeCycleSafe(name string, st *types.Struct, skip map[string]struct{}) error {
+	r.emitCopyCtxHelper()
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "\tif in == nil {\n\t\treturn nil\n\t}\n\n")
+	fmt.Fprintf(&body, "\tif existing, ok := ctx.seen[unsafe.Pointer(in)]; ok {\n\t\treturn (*%s)(existing)\n\t}\n\n", name)
+	fmt.Fprintf(&body, "\tout := new(%s)\n\tctx.seen[unsafe.Pointer(in)] = unsafe.Pointer(out)\n\t*out = *in\n\n", name)
+
+	if err := r.renderFields(&body, st, skip, 1, "ctx"); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(&body, "\treturn out\n")
+
+	fmt.Fprintf(&r.body, "\n// deepCopyWithCtx returns a deep copy of in, reusing ctx's clones so that\n// shared pointers stay shared and cycles terminate instead of recursing\n// forever.\nfunc (in *%s) deepCopyWithCtx(ctx *copyCtx) *%s {\n%s}\n",
+		name, name, body.String())
+
+	fmt.Fprintf(&r.body, "\n// %s returns a deep copy of in, safe for self-referential and shared\n// pointer graphs.\nfunc (in *%s) %s() %s {\n\treturn in.deepCopyWithCtx(newCopyCtx())\n}\n",
+		r.gen.method, name, r.gen.method, r.primaryReturnType("*"+name))
+
+	return nil
+}
+
+// emitCopyCtxHelper writes the copyCtx type and its constructor once per
+// Generate call, the first time a -cycle-safe type needs it.
+func (r *renderer) emitCopyCtxHelper() {
+	if r.wroteCopyCtx {
+		return
+	}
+	r.wroteCopyCtx = true
+	r.imports["unsafe"] = "unsafe"
+
+	fmt.Fprint(&r.helpers, `
+// copyCtx tracks pointers already cloned during a single deep copy, so
+// that shared and cyclic structures are cloned once and pointer identity
+// is preserved instead of the generator recursing forever.
+type copyCtx struct {
+	seen map[unsafe.Pointer]unsafe.Pointer
+}
+
+func newCopyCtx() *copyCtx {
+	return &copyCtx{seen: make(map[unsafe.Pointer]unsafe.Pointer)}
+}
+`)
+}
+
+// renderFields walks st's fields, skipping any selector present in skip,
+// and writes the copy statements for each into w. ctx is the name of the
+// in-scope *copyCtx variable to thread through pointer fields in
+// -cycle-safe mode, or "" outside that mode.
+func (r *renderer) renderFields(w io.Writer, st *types.Struct, skip map[string]struct{}, depth int, ctx string) error {
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		if _, skipped := skip[field.Name()]; skipped {
+			continue
+		}
+
+		if err := r.renderField(w, "in."+field.Name(), "out."+field.Name(), field.Type(), depth, ctx); err != nil {
+			return fmt.Errorf("field %s: %v", field.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// renderField emits the statements needed to deep copy src into dst, or
+// nothing at all when a plain struct assignment already did the job.
+// depth is 1 for a type's direct fields, 2 for their fields, and so on;
+// once it passes the generator's maxDepth the remainder is left shallow.
+// Slice and map values get new storage, but their elements are copied by
+// plain assignment, so pointers, slices and maps held in them still refer
+// to the original's data.
+// ctx is the in-scope *copyCtx variable name in -cycle-safe mode, else "".
+func (r *renderer) renderField(w io.Writer, src, dst string, typ types.Type, depth int, ctx string) error {
+	if r.gen.maxDepth > 0 && depth > r.gen.maxDepth {
+		return nil
+	}
+
+	switch t := typ.(type) {
+	case *types.Pointer:
+		elem := t.Elem()
+
+		if ctx != "" {
+			if named, ok := elem.(*types.Named); ok && r.callsMethod(named, "deepCopyWithCtx") {
+				fmt.Fprintf(w, "\tif %s != nil {\n\t\t%s = %s.deepCopyWithCtx(%s)\n\t}\n", src, dst, src, ctx)
+				return nil
+			}
+		}
+
+		// Prefer calling the pointee's own copy method directly over the
+		// generic fallback below: that method already knows how to deep
+		// copy itself, and (since it returns a concrete *T or fills a *T
+		// in place) composes correctly with dst being a pointer slot.
+		if named, ok := elem.(*types.Named); ok {
+			switch {
+			case r.gen.into && r.callsMethod(named, "DeepCopyInto"):
+				fmt.Fprintf(w, "\tif %s != nil {\n\t\t%s = new(%s)\n\t\t%s.DeepCopyInto(%s)\n\t}\n", src, dst, r.rawType(named), src, dst)
+				return nil
+
+			case r.callsMethod(named, r.gen.method):
+				if r.gen.pointerReceiver {
+					fmt.Fprintf(w, "\tif %s != nil {\n\t\t%s = %s.%s()\n\t}\n", src, dst, src, r.gen.method)
+				} else {
+					fmt.Fprintf(w, "\tif %s != nil {\n\t\ttmp := %s.%s()\n\t\t%s = &tmp\n\t}\n", src, src, r.gen.method, dst)
+				}
+				return nil
+			}
+		}
+
+		// dst is often a selector expression (e.g. "out.Child"), which
+		// can't appear on the left of ":=", so the recursion reads from
+		// the original pointee, writes into a plain local variable (named
+		// after depth, so a nested pointer doesn't shadow it) and assigns
+		// its address to dst at the end.
+		tmp := fmt.Sprintf("tmp%d", depth)
+		fmt.Fprintf(w, "\tif %s != nil {\n", src)
+		fmt.Fprintf(w, "\t\t%s := *%s\n", tmp, src)
+		if err := r.renderField(w, "(*"+src+")", tmp, elem, depth+1, ctx); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\t\t%s = &%s\n", dst, tmp)
+		fmt.Fprintf(w, "\t}\n")
+
+	case *types.Slice:
+		fmt.Fprintf(w, "\tif %s != nil {\n", src)
+		fmt.Fprintf(w, "\t\t%s = make(%s, len(%s))\n", dst, r.rawType(t), src)
+		fmt.Fprintf(w, "\t\tcopy(%s, %s)\n", dst, src)
+		fmt.Fprintf(w, "\t}\n")
+
+	case *types.Map:
+		fmt.Fprintf(w, "\tif %s != nil {\n", src)
+		fmt.Fprintf(w, "\t\t%s = make(%s, len(%s))\n", dst, r.rawType(t), src)
+		fmt.Fprintf(w, "\t\tfor k, v := range %s {\n", src)
+		fmt.Fprintf(w, "\t\t\t%s[k] = v\n", dst)
+		fmt.Fprintf(w, "\t\t}\n")
+		fmt.Fprintf(w, "\t}\n")
+
+	case *types.Named:
+		switch {
+		case r.gen.into && r.callsMethod(t, "DeepCopyInto"):
+			fmt.Fprintf(w, "\t%s.DeepCopyInto(&%s)\n", src, dst)
+			return nil
+
+		case r.callsMethod(t, r.gen.method):
+			fmt.Fprintf(w, "\t%s = %s.%s()\n", dst, src, r.gen.method)
+			return nil
+
+		default:
+			if !r.inBounds(t) {
+				// Outside -bounding-dirs and has no copy method of its
+				// own: treat it as a leaf rather than reach into a
+				// package we don't own. The outer struct assignment
+				// already gave it a shallow copy.
+				return nil
+			}
+			// No copy method of its own: the outer struct assignment
+			// already copied the value, so only the reference-typed parts
+			// of its underlying type (a struct's fields, or a map's or
+			// slice's storage) need attention.
+			return r.renderField(w, src, dst, t.Underlying(), depth, ctx)
+		}
+
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			field := t.Field(i)
+			if err := r.renderField(w, src+"."+field.Name(), dst+"."+field.Name(), field.Type(), depth+1, ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// callsMethod reports whether named either already declares method (a type
+// from a dependency we're not regenerating) or is itself part of the
+// current generation batch and will declare it by the time this file is
+// compiled.
+func (r *renderer) callsMethod(named *types.Named, method string) bool {
+	if named.Obj().Pkg() == r.pkg.Types {
+		if _, ok := r.batch[named.Obj().Name()]; ok {
+			return true
+		}
+	}
+	return hasMethod(named, method)
+}
+
+// inBounds reports whether named's defining package is within one of the
+// generator's -bounding-dirs (always true when none were given, or when
+// named is part of the current generation batch).
+func (r *renderer) inBounds(named *types.Named) bool {
+	if len(r.gen.boundingDirs) == 0 {
+		return true
+	}
+	if named.Obj().Pkg() == r.pkg.Types {
+		return true
+	}
+
+	path := named.Obj().Pkg().Path()
+	for _, dir := range r.gen.boundingDirs {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// needsDeepCopy reports whether values of typ alias mutable state that a
+// plain assignment or copy() would share instead of cloning.
+func needsDeepCopy(typ types.Type) bool {
+	switch t := typ.(type) {
+	case *types.Pointer, *types.Slice, *types.Map:
+		return true
+	case *types.Named:
+		return needsDeepCopy(t.Underlying())
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if needsDeepCopy(t.Field(i).Type()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasMethod(named *types.Named, method string) bool {
+	for i := 0; i < named.NumMethods(); i++ {
+		if named.Method(i).Name() == method {
+			return true
+		}
+	}
+	return false
+}
+
+// rawType renders typ as Go source against the destination package r.pkg:
+// identifiers from any other package are qualified with that package's name
+// and recorded in r.imports so writeTo can emit the import automatically,
+// while identifiers belonging to r.pkg itself are rendered bare, since
+// "pkg.Type" does not compile inside pkg's own generated file.
+func (r *renderer) rawType(typ types.Type) string {
+	return types.TypeString(typ, func(pkg *types.Package) string {
+		if pkg == r.pkg.Types {
+			return ""
+		}
+		r.imports[pkg.Path()] = pkg.Name()
+		return pkg.Name()
+	})
+}
+
+// writeTo prints the package clause, build tags, imports and accumulated
+// method bodies to w as a single Go source file.
+func (r *renderer) writeTo(w io.Writer) error {
+	var out bytes.Buffer
+
+	// Each -tags value is a comma-separated list; every tag given is
+	// required, so they're joined into a single //go:build constraint.
+	var tags []string
+	for _, tag := range r.gen.buildTags {
+		tags = append(tags, withoutEmpty(strings.Split(tag, ","))...)
+	}
+	if len(tags) > 0 {
+		fmt.Fprintf(&out, "//go:build %s\n\n", strings.Join(tags, " && "))
+	}
+
+	fmt.Fprintf(&out, "// Code generated by deep-copy. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n", r.pkg.Types.Name())
+
+	if len(r.imports) > 0 {
+		paths := make([]string, 0, len(r.imports))
+		for path := range r.imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		fmt.Fprintf(&out, "\nimport (\n")
+		for _, path := range paths {
+			fmt.Fprintf(&out, "\t%q\n", path)
+		}
+		fmt.Fprintf(&out, ")\n")
+	}
+
+	out.Write(r.helpers.Bytes())
+	out.Write(r.body.Bytes())
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
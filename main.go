@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"golang.org/x/tools/go/packages"
@@ -22,11 +23,15 @@ var (
 	returnInterfaceDepF         = flag.String("return-interface-dep", "", "return interface dep name")
 	returnInterfaceDepPathF     = flag.String("return-interface-dep-path", "", "return interface dep path")
 	allowedCopyToAnotherStructF = flag.Bool("another-struct", false, "add parameter for copy to another struct. allowed only with pointer-receiver")
-
-	typesF     typesVal
-	skipsF     skipsVal
-	outputF    outputVal
-	buildTagsF buildTagsVal
+	tagPrefixF                  = flag.String("tag-prefix", "deepcopy", "marker namespace used to discover types, e.g. +<prefix>-gen=true")
+	intoF                       = flag.Bool("into", false, "generate DeepCopyInto(out *T) plus a thin DeepCopy() *T wrapper, instead of a single DeepCopy() method")
+	cycleSafeF                  = flag.Bool("cycle-safe", false, "generate methods that detect pointer cycles and shared pointers at runtime, instead of -maxdepth's static cutoff")
+
+	typesF        typesVal
+	skipsF        skipsVal
+	outputF       outputVal
+	buildTagsF    buildTagsVal
+	boundingDirsF boundingDirsVal
 )
 
 type typesVal []string
@@ -112,6 +117,47 @@ func (f *outputVal) Open() (io.WriteCloser, error) {
 	return f.file, nil
 }
 
+// generatedFileName is the file name used when writing one output file per
+// package, Kubernetes zz_generated.deepcopy.go style.
+const generatedFileName = "zz_generated.deepcopy.go"
+
+// OpenFor returns the writer to use for pkg's generated code. With a single
+// matched package it behaves exactly like Open, honoring -o/STDOUT. With
+// more than one (a "./..." pattern matching several packages), -o is
+// ignored in favor of one file per package: written alongside the
+// package's own sources, or mirrored under -o's directory when -o names
+// one.
+func (f *outputVal) OpenFor(pkg *packages.Package, multi bool) (io.WriteCloser, error) {
+	if !multi {
+		return f.Open()
+	}
+
+	dir := packageDir(pkg)
+	if f.name != "" && f.name != "stdout" {
+		dir = filepath.Join(f.name, dir)
+	}
+
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, fmt.Errorf("creating output dir: %v", err)
+	}
+
+	path := filepath.Join(dir, generatedFileName)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %v", path)
+	}
+
+	return file, nil
+}
+
+func packageDir(pkg *packages.Package) string {
+	if len(pkg.GoFiles) == 0 {
+		return "."
+	}
+	return filepath.Dir(pkg.GoFiles[0])
+}
+
 type buildTagsVal []string
 
 func (b *buildTagsVal) String() string {
@@ -123,56 +169,110 @@ func (b *buildTagsVal) Set(v string) error {
 	return nil
 }
 
+type boundingDirsVal []string
+
+func (b *boundingDirsVal) String() string {
+	return strings.Join(*b, ",")
+}
+
+func (b *boundingDirsVal) Set(v string) error {
+	*b = append(*b, v)
+	return nil
+}
+
 func init() {
 	flag.Var(&typesF, "type", "the concrete type. Multiple flags can be specified")
 	flag.Var(&skipsF, "skip", "comma-separated field/slice/map selectors to shallow copy. Multiple flags can be specified")
-	flag.Var(&outputF, "o", "the output file to write to. Defaults to STDOUT")
+	flag.Var(&outputF, "o", "the output file to write to. Defaults to STDOUT. Ignored when generating for more than one package")
 	flag.Var(&buildTagsF, "tags", "comma-separated build tags to add to generated file")
+	flag.Var(&boundingDirsF, "bounding-dirs", "import path prefix outside of which referenced types are treated as leaves. Multiple flags can be specified")
 }
 
 func main() {
 	flag.Parse()
 
-	if len(typesF) == 0 || typesF[0] == "" {
-		log.Fatalln("no type given")
-	}
-
-	if flag.NArg() != 1 {
+	if flag.NArg() == 0 {
 		log.Fatalln("No package path given")
 	}
 
 	sl := deepcopy.SkipLists(skipsF)
-	generator := deepcopy.NewGenerator(*pointerReceiverF, *methodF, sl, *maxDepthF, *allowedCopyToAnotherStructF, *returnInterfaceF, *returnInterfaceDepF, *returnInterfaceDepPathF, buildTagsF)
+	generator := deepcopy.NewGenerator(*pointerReceiverF, *methodF, sl, *maxDepthF, *allowedCopyToAnotherStructF, *returnInterfaceF, *returnInterfaceDepF, *returnInterfaceDepPathF, buildTagsF).
+		WithTagPrefix(*tagPrefixF).
+		GenerateInto(*intoF).
+		WithBoundingDirs(boundingDirsF).
+		WithCycleSafe(*cycleSafeF)
 
-	output, err := outputF.Open()
-	if err != nil {
-		log.Fatalln("Error initializing output file:", err)
-	}
-
-	err = run(generator, output, flag.Args()[0], typesF)
+	err := run(generator, &outputF, flag.Args(), typesF)
 	if err != nil {
 		log.Fatalln("Error generating deep copy method:", err)
 	}
-
-	output.Close()
 }
 
+// run generates deep copy methods for every package matched by patterns
+// (which may include "./..." style recursive patterns, and more than one
+// pattern at once), writing one output file per package when more than one
+// package matched.
 func run(
-	g deepcopy.Generator, w io.Writer, path string, types typesVal,
+	g deepcopy.Generator, out *outputVal, patterns []string, types typesVal,
 ) error {
-	packages, err := load(path)
+	pkgs, err := load(patterns)
 	if err != nil {
-		return fmt.Errorf("loading package: %v", err)
+		return fmt.Errorf("loading packages: %v", err)
 	}
-	if len(packages) == 0 {
+	if len(pkgs) == 0 {
 		return errors.New("no package found")
 	}
 
-	return g.Generate(w, types, packages[0])
+	multi := len(pkgs) > 1
+
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			return fmt.Errorf("package %s: %v", pkg.PkgPath, pkg.Errors[0])
+		}
+
+		pkgTypes := []string(types)
+		switch {
+		case multi && len(pkgTypes) > 0:
+			// The same explicit -type list is shared across every package a
+			// recursive pattern matched, so a type that only exists in some
+			// of them isn't an error here: skip packages with none of the
+			// requested types rather than aborting the whole run.
+			pkgTypes = g.TypesPresent(pkg, pkgTypes)
+			if len(pkgTypes) == 0 {
+				continue
+			}
+
+		case multi:
+			// Same reasoning for marker discovery: a recursive pattern will
+			// commonly match packages that carry no +<tag-prefix>-gen
+			// markers at all, and that's not an error either.
+			discovered, err := g.DiscoverTypes(pkg)
+			if err != nil {
+				return fmt.Errorf("discovering marked types in %s: %v", pkg.PkgPath, err)
+			}
+			if len(discovered) == 0 {
+				continue
+			}
+		}
+
+		w, err := out.OpenFor(pkg, multi)
+		if err != nil {
+			return fmt.Errorf("opening output for %s: %v", pkg.PkgPath, err)
+		}
+
+		err = g.Generate(w, pkgTypes, pkg)
+		w.Close()
+		if err != nil {
+			return fmt.Errorf("package %s: %v", pkg.PkgPath, err)
+		}
+	}
+
+	return nil
 }
 
-func load(patterns string) ([]*packages.Package, error) {
+func load(patterns []string) ([]*packages.Package, error) {
 	return packages.Load(&packages.Config{
-		Mode: packages.NeedName | packages.NeedFiles | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedDeps | packages.NeedImports,
-	}, patterns)
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedTypes | packages.NeedTypesInfo |
+			packages.NeedDeps | packages.NeedImports | packages.NeedSyntax,
+	}, patterns...)
 }